@@ -1,5 +1,3 @@
-// +build linux
-
 package conntrack
 
 import (
@@ -16,12 +14,17 @@ import (
 )
 
 type Conntrack struct {
-	ps           system.PS
-	Path         string
-	Dirs         []string
-	Files        []string
-	CollectStats bool `toml:"collect_stats"`
-	PerCPU       bool `toml:"percpu"`
+	ps                  system.PS
+	Path                string
+	ProcPath            string `toml:"proc_path"`
+	Dirs                []string
+	Files               []string
+	Zones               []string
+	CollectStats        bool `toml:"collect_stats"`
+	CollectPerState     bool `toml:"collect_per_state"`
+	ReportMissingAsZero bool `toml:"report_missing_as_zero"`
+	PerCPU              bool `toml:"percpu"`
+	UseNetlink          bool `toml:"use_netlink"`
 }
 
 const (
@@ -58,6 +61,35 @@ func (c *Conntrack) setDefaults() {
 	}
 }
 
+// dirs returns the configured directories with ProcPath prepended to each,
+// so a bind-mounted host /proc (e.g. /hostfs/proc inside a container) can
+// be searched without having to repeat it in every entry of Dirs.
+func (c *Conntrack) dirs() []string {
+	if c.ProcPath == "" {
+		return c.Dirs
+	}
+
+	dirs := make([]string, 0, len(c.Dirs))
+	for _, dir := range c.Dirs {
+		dirs = append(dirs, c.procPath(dir))
+	}
+	return dirs
+}
+
+// procPath prepends ProcPath to an absolute procfs path, if configured.
+//
+// node_exporter builds these paths with github.com/prometheus/procfs;
+// this plugin only ever joins a handful of static, already-known
+// filenames onto a base directory, so pulling in procfs's much larger
+// API surface for that one operation isn't worth the dependency.
+// filepath.Join covers it directly.
+func (c *Conntrack) procPath(path string) string {
+	if c.ProcPath == "" {
+		return path
+	}
+	return filepath.Join(c.ProcPath, path)
+}
+
 func (c *Conntrack) Description() string {
 	return "Collects conntrack stats from the configured directories and files."
 }
@@ -75,10 +107,40 @@ var sampleConfig = `
    ## Directories to search within for the conntrack files above.
    ## Missing directories will be ignored.
    dirs = ["/proc/sys/net/ipv4/netfilter","/proc/sys/net/netfilter"]
+
+   ## proc_path is prepended to each entry in dirs above, which allows
+   ## pointing telegraf at a host /proc bind-mounted somewhere other than
+   ## /proc, e.g. inside a container with the host /proc mounted at
+   ## /hostfs/proc: proc_path = "/hostfs"
+   # proc_path = ""
+
    ## If true, collect conntrack stats
    collect_stats = false
    ## Whether to report per-cpu stats or not
    percpu = false
+
+   ## If true, parse /proc/net/nf_conntrack (or /proc/net/ip_conntrack) and
+   ## additionally report entries broken down by l4proto, and for tcp, by
+   ## connection state. Off by default since the file can be large.
+   collect_per_state = false
+
+   ## Older kernels (<2.6.35) and some backports don't populate every field
+   ## of the conntrack stats; by default those zero-only columns are
+   ## omitted rather than reported as misleading zeros. Set this to true
+   ## to restore the previous behavior of always reporting every field.
+   report_missing_as_zero = false
+
+   ## If true, collect additional metrics (per-zone entry counts, the
+   ## expectation table size, and an age-bucket histogram of entry
+   ## timeouts) over netlink, on top of the procfs-based collection above,
+   ## which always runs. Requires CAP_NET_ADMIN; when netlink is
+   ## unavailable only the procfs-based metrics are reported, and the
+   ## netlink_available field is 0.
+   use_netlink = false
+
+   ## Restrict netlink zone metrics to this list of zone IDs. An empty
+   ## list (the default) reports every zone.
+   # zones = ["0"]
 `
 
 func (c *Conntrack) SampleConfig() string {
@@ -91,7 +153,7 @@ func (c *Conntrack) Gather(acc telegraf.Accumulator) error {
 	var metricKey string
 	fields := make(map[string]interface{})
 
-	for _, dir := range c.Dirs {
+	for _, dir := range c.dirs() {
 		for _, file := range c.Files {
 			// NOTE: no system will have both nf_ and ip_ prefixes,
 			// so we're safe to branch on suffix only.
@@ -121,53 +183,40 @@ func (c *Conntrack) Gather(acc telegraf.Accumulator) error {
 		}
 	}
 
-	if c.CollectStats {
-		stats, err := c.ps.NetConntrack(c.PerCPU)
-		if err != nil {
-			acc.AddError(fmt.Errorf("E! failed to retrieve conntrack statistics: %v", err))
+	if cnt, ok := fields["ip_conntrack_count"].(float64); ok {
+		if max, ok := fields["ip_conntrack_max"].(float64); ok && max != 0 {
+			fields["entries_ratio"] = cnt / max
 		}
+	}
 
-		for i, sts := range stats {
-			var tags map[string]string
-			if c.PerCPU {
-				tags = map[string]string{
-					"cpu": fmt.Sprintf("cpu%d", i),
-				}
-			} else {
-				tags = map[string]string{
-					"cpu": "all",
-				}
-			}
+	collected := len(fields) > 0
 
-			statFields := map[string]interface{}{
-				"entries":        sts.Entries,       // entries in the conntrack table
-				"searched":       sts.Searched,      // conntrack table lookups performed
-				"found":          sts.Found,         // searched entries which were successful
-				"new":            sts.New,           // entries added which were not expected before
-				"invalid":        sts.Invalid,       // packets seen which can not be tracked
-				"ignore":         sts.Ignore,        // packets seen which are already connected to an entry
-				"delete":         sts.Delete,        // entries which were removed
-				"delete_list":    sts.DeleteList,    // entries which were put to dying list
-				"insert":         sts.Insert,        // entries inserted into the list
-				"insert_failed":  sts.InsertFailed,  // insertion attempted but failed (same entry exists)
-				"drop":           sts.Drop,          // packets dropped due to conntrack failure
-				"early_drop":     sts.EarlyDrop,     // dropped entries to make room for new ones, if maxsize reached
-				"icmp_error":     sts.IcmpError,     // Subset of invalid. Packets that can't be tracked d/t error
-				"expect_new":     sts.ExpectNew,     // Entries added after an expectation was already present
-				"expect_create":  sts.ExpectCreate,  // Expectations added
-				"expect_delete":  sts.ExpectDelete,  // Expectations deleted
-				"search_restart": sts.SearchRestart, // onntrack table lookups restarted due to hashtable resizes
-			}
-			acc.AddCounter(inputName, statFields, tags)
+	if c.CollectPerState {
+		if c.gatherPerState(acc) {
+			collected = true
 		}
 	}
 
-	if len(fields) == 0 {
+	if c.CollectStats {
+		if c.gatherConntrackStats(acc) {
+			collected = true
+		}
+	}
+
+	if c.UseNetlink {
+		if c.gatherNetlink(acc) {
+			collected = true
+		}
+	}
+
+	if !collected {
 		return fmt.Errorf("Conntrack input failed to collect metrics. " +
 			"Is the conntrack kernel module loaded?")
 	}
 
-	acc.AddFields(inputName, fields, nil)
+	if len(fields) > 0 {
+		acc.AddFields(inputName, fields, nil)
+	}
 	return nil
 }
 
@@ -0,0 +1,140 @@
+package conntrack
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgeBucket(t *testing.T) {
+	tests := []struct {
+		timeout uint32
+		want    string
+	}{
+		{timeout: 0, want: "short"},
+		{timeout: 59, want: "short"},
+		{timeout: 60, want: "medium"},
+		{timeout: 599, want: "medium"},
+		{timeout: 600, want: "long"},
+		{timeout: 431999, want: "long"},
+	}
+
+	for _, tt := range tests {
+		if got := ageBucket(tt.timeout); got != tt.want {
+			t.Errorf("ageBucket(%d) = %q, want %q", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+// fakeNetlinkConn lets tests drive gatherNetlink without a real netlink
+// socket, which requires CAP_NET_ADMIN and a live conntrack table.
+type fakeNetlinkConn struct {
+	flows       []netlinkFlow
+	dumpErr     error
+	expectCount int
+	expectErr   error
+	closed      bool
+}
+
+func (f *fakeNetlinkConn) Dump() ([]netlinkFlow, error) { return f.flows, f.dumpErr }
+func (f *fakeNetlinkConn) ExpectCount() (int, error)    { return f.expectCount, f.expectErr }
+func (f *fakeNetlinkConn) Close() error                 { f.closed = true; return nil }
+
+func withFakeNetlink(t *testing.T, conn netlinkConn, dialErr error) {
+	saved := dialNetlink
+	dialNetlink = func() (netlinkConn, error) {
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		return conn, nil
+	}
+	t.Cleanup(func() { dialNetlink = saved })
+}
+
+func TestGatherNetlinkDialFailureFallsBack(t *testing.T) {
+	withFakeNetlink(t, nil, errors.New("permission denied, CAP_NET_ADMIN required"))
+
+	c := &Conntrack{UseNetlink: true}
+	acc := &testutil.Accumulator{}
+
+	ok := c.gatherNetlink(acc)
+	assert.False(t, ok)
+	acc.AssertContainsFields(t, inputName, map[string]interface{}{"netlink_available": 0})
+}
+
+func TestGatherNetlinkDumpFailureFallsBack(t *testing.T) {
+	fake := &fakeNetlinkConn{dumpErr: errors.New("ENOBUFS")}
+	withFakeNetlink(t, fake, nil)
+
+	c := &Conntrack{UseNetlink: true}
+	acc := &testutil.Accumulator{}
+
+	ok := c.gatherNetlink(acc)
+	assert.False(t, ok)
+	assert.True(t, fake.closed)
+	acc.AssertContainsFields(t, inputName, map[string]interface{}{"netlink_available": 0})
+}
+
+func TestGatherNetlinkZoneFilter(t *testing.T) {
+	fake := &fakeNetlinkConn{
+		flows: []netlinkFlow{
+			{Zone: 0, Timeout: 30},
+			{Zone: 0, Timeout: 700},
+			{Zone: 7, Timeout: 30},
+		},
+		expectCount: 2,
+	}
+	withFakeNetlink(t, fake, nil)
+
+	c := &Conntrack{UseNetlink: true, Zones: []string{"0"}}
+	acc := &testutil.Accumulator{}
+
+	ok := c.gatherNetlink(acc)
+	require.True(t, ok)
+
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(2)}, map[string]string{"zone": "0"})
+	for _, m := range acc.Metrics {
+		assert.NotEqual(t, "7", m.Tags["zone"], "zone 7 should have been filtered out")
+	}
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(1)}, map[string]string{"age_bucket": "short"})
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(1)}, map[string]string{"age_bucket": "long"})
+	// expect_entries and netlink_available are reported as separate
+	// metrics after the zone/age_bucket ones, so look them up by field.
+	var expectEntries, netlinkAvailable interface{}
+	for _, m := range acc.Metrics {
+		if v, ok := m.Fields["expect_entries"]; ok {
+			expectEntries = v
+		}
+		if v, ok := m.Fields["netlink_available"]; ok {
+			netlinkAvailable = v
+		}
+	}
+	assert.Equal(t, int64(2), expectEntries)
+	assert.Equal(t, 1, netlinkAvailable)
+}
+
+func TestGatherNetlinkNoZoneFilterReportsAll(t *testing.T) {
+	fake := &fakeNetlinkConn{
+		flows: []netlinkFlow{
+			{Zone: 0, Timeout: 30},
+			{Zone: 7, Timeout: 30},
+		},
+	}
+	withFakeNetlink(t, fake, nil)
+
+	c := &Conntrack{UseNetlink: true}
+	acc := &testutil.Accumulator{}
+
+	require.True(t, c.gatherNetlink(acc))
+
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(1)}, map[string]string{"zone": "0"})
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(1)}, map[string]string{"zone": "7"})
+}
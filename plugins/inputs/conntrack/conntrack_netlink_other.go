@@ -0,0 +1,15 @@
+// +build !linux
+
+package conntrack
+
+import "github.com/influxdata/telegraf"
+
+// gatherNetlink is a no-op on non-Linux platforms, since
+// nfnetlink_conntrack is a Linux kernel subsystem. It still reports
+// netlink_available=0 so the use_netlink=true fallback to the
+// procfs-based collection is visible to the same dashboards/alerts on
+// every platform, and returns false so the caller falls back to it.
+func (c *Conntrack) gatherNetlink(acc telegraf.Accumulator) bool {
+	acc.AddGauge(inputName, map[string]interface{}{"netlink_available": 0}, nil)
+	return false
+}
@@ -0,0 +1,109 @@
+package conntrack
+
+import (
+	"fmt"
+	"reflect"
+	"unicode"
+
+	"github.com/influxdata/telegraf"
+)
+
+// gatherConntrackStats reports the counters gopsutil's NetConntrack
+// exposes (net.ConntrackStat). The set of populated fields varies by
+// kernel: older kernels (<2.6.35) never set search_restart, while some
+// backports add fields newer than whatever this build of gopsutil knows
+// about being zero-valued. Rather than hard-coding field names, the
+// struct is walked via reflection so columns that are zero across every
+// reported cpu are omitted instead of being reported as misleading
+// zeros; report_missing_as_zero restores the old always-report-every-
+// field behavior.
+func (c *Conntrack) gatherConntrackStats(acc telegraf.Accumulator) bool {
+	// NetConntrack is only implemented for Linux by gopsutil; on other
+	// platforms it returns an error, which is surfaced below rather
+	// than failing the whole gather.
+	stats, err := c.ps.NetConntrack(c.PerCPU)
+	if err != nil {
+		acc.AddError(fmt.Errorf("E! failed to retrieve conntrack statistics: %v", err))
+		return false
+	}
+	if len(stats) == 0 {
+		return false
+	}
+
+	t := reflect.TypeOf(stats[0])
+	// isUint guards against a future gopsutil adding or widening a field
+	// to something Value.Uint() can't handle; telegraf doesn't recover
+	// per-plugin panics, so an unrecognized field is skipped rather than
+	// assumed to be a uint.
+	isUint := func(k reflect.Kind) bool {
+		return k >= reflect.Uint && k <= reflect.Uintptr
+	}
+
+	present := make([]bool, t.NumField())
+	for i := range present {
+		if !isUint(t.Field(i).Type.Kind()) {
+			continue
+		}
+		if c.ReportMissingAsZero {
+			present[i] = true
+			continue
+		}
+		for _, sts := range stats {
+			if reflect.ValueOf(sts).Field(i).Uint() != 0 {
+				present[i] = true
+				break
+			}
+		}
+	}
+
+	var cpuAll map[string]interface{}
+	if c.PerCPU {
+		cpuAll = make(map[string]interface{})
+	}
+
+	for i, sts := range stats {
+		tags := map[string]string{"cpu": "all"}
+		if c.PerCPU {
+			tags["cpu"] = fmt.Sprintf("cpu%d", i)
+		}
+
+		v := reflect.ValueOf(sts)
+		statFields := make(map[string]interface{})
+		for f := 0; f < t.NumField(); f++ {
+			if !present[f] {
+				continue
+			}
+			name := fieldToSnakeCase(t.Field(f).Name)
+			val := v.Field(f).Uint()
+			statFields[name] = uint32(val)
+			if c.PerCPU {
+				sum, _ := cpuAll[name].(uint32)
+				cpuAll[name] = sum + uint32(val)
+			}
+		}
+		acc.AddCounter(inputName, statFields, tags)
+	}
+
+	if c.PerCPU && len(cpuAll) > 0 {
+		acc.AddCounter(inputName, cpuAll, map[string]string{"cpu": "all"})
+	}
+
+	return true
+}
+
+// fieldToSnakeCase converts a Go exported field name such as
+// "SearchRestart" to the metric name telegraf has historically used for
+// it, "search_restart".
+func fieldToSnakeCase(name string) string {
+	var out []rune
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				out = append(out, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
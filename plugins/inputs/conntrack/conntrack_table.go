@@ -0,0 +1,112 @@
+package conntrack
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+)
+
+// conntrackTableFiles lists the well-known locations of the full
+// conntrack table, newest kernel name first. Like Files above, the nf_
+// and ip_ prefixes are mutually exclusive across kernel versions.
+var conntrackTableFiles = []string{
+	"/proc/net/nf_conntrack",
+	"/proc/net/ip_conntrack",
+}
+
+// tcpStates are the connection states conntrack reports for tcp entries,
+// e.g. "tcp 6 431999 ESTABLISHED ...". Anything else found in that
+// column is treated as an unrecognized/malformed row and skipped.
+var tcpStates = map[string]bool{
+	"SYN_SENT":    true,
+	"SYN_RECV":    true,
+	"ESTABLISHED": true,
+	"FIN_WAIT":    true,
+	"CLOSE_WAIT":  true,
+	"LAST_ACK":    true,
+	"TIME_WAIT":   true,
+	"CLOSE":       true,
+	"NONE":        true,
+}
+
+// gatherPerState parses the full conntrack table (nf_conntrack, falling
+// back to the older ip_conntrack) and emits, per l4proto, the number of
+// tracked connections, further broken down by connection state for tcp.
+// Lines that don't parse are skipped rather than failing the gather,
+// since this is a live, frequently-churning kernel table. It returns
+// whether the table could be read at all, so callers can tell a
+// legitimately empty table apart from a missing/unreadable one.
+func (c *Conntrack) gatherPerState(acc telegraf.Accumulator) bool {
+	var fName string
+	for _, f := range conntrackTableFiles {
+		candidate := c.procPath(f)
+		if _, err := os.Stat(candidate); err == nil {
+			fName = candidate
+			break
+		}
+	}
+
+	if fName == "" {
+		acc.AddError(fmt.Errorf("E! collect_per_state enabled but neither %s", strings.Join(conntrackTableFiles, " nor ")))
+		return false
+	}
+
+	file, err := os.Open(fName)
+	if err != nil {
+		acc.AddError(fmt.Errorf("E! failed to open file '%s': %v", fName, err))
+		return false
+	}
+	defer file.Close()
+
+	protoCounts := make(map[string]int64)
+	tcpStateCounts := make(map[string]int64)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// ipv4 lines start "ipv4 2 <proto> <protonum> <timeout> ...",
+		// ipv6 lines start "ipv6 10 <proto> <protonum> <timeout> ...".
+		// A word count alone doesn't prove that shape, so also check the
+		// family name and that the family/proto numbers actually parse as
+		// integers before trusting fields[2] as an l4proto.
+		if len(fields) < 5 || (fields[0] != "ipv4" && fields[0] != "ipv6") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[3]); err != nil {
+			continue
+		}
+
+		proto := fields[2]
+		protoCounts[proto]++
+
+		// fields[4] is the entry's timeout; tcp alone has a state column
+		// right after it, e.g. "tcp 6 108 ESTABLISHED ...".
+		if proto == "tcp" && len(fields) >= 6 {
+			if state := fields[5]; tcpStates[state] {
+				tcpStateCounts[state]++
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		acc.AddError(fmt.Errorf("E! failed to read file '%s': %v", fName, err))
+	}
+
+	for proto, count := range protoCounts {
+		acc.AddGauge(inputName, map[string]interface{}{"entries": count},
+			map[string]string{"l4proto": proto})
+	}
+
+	for state, count := range tcpStateCounts {
+		acc.AddGauge(inputName, map[string]interface{}{"entries": count},
+			map[string]string{"l4proto": "tcp", "state": state})
+	}
+
+	return true
+}
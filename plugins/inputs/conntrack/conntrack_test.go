@@ -1,5 +1,3 @@
-// +build linux
-
 package conntrack
 
 import (
@@ -96,6 +94,132 @@ func TestConfigsUsed(t *testing.T) {
 		})
 }
 
+func TestEntriesRatio(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "tmp1")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	assert.NoError(t, ioutil.WriteFile(path.Join(tmpdir, "ip_conntrack_count"), []byte("100"), 0660))
+	assert.NoError(t, ioutil.WriteFile(path.Join(tmpdir, "ip_conntrack_max"), []byte("1000"), 0660))
+
+	c := &Conntrack{
+		Dirs:  []string{tmpdir},
+		Files: []string{"ip_conntrack_count", "ip_conntrack_max"},
+	}
+	acc := &testutil.Accumulator{}
+
+	assert.NoError(t, c.Gather(acc))
+	acc.AssertContainsFields(t, inputName, map[string]interface{}{
+		"ip_conntrack_count": float64(100),
+		"ip_conntrack_max":   float64(1000),
+		"entries_ratio":      float64(0.1),
+	})
+}
+
+func TestEntriesRatioSkippedWhenMaxZero(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "tmp1")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	assert.NoError(t, ioutil.WriteFile(path.Join(tmpdir, "ip_conntrack_count"), []byte("100"), 0660))
+	assert.NoError(t, ioutil.WriteFile(path.Join(tmpdir, "ip_conntrack_max"), []byte("0"), 0660))
+
+	c := &Conntrack{
+		Dirs:  []string{tmpdir},
+		Files: []string{"ip_conntrack_count", "ip_conntrack_max"},
+	}
+	acc := &testutil.Accumulator{}
+
+	assert.NoError(t, c.Gather(acc))
+	for _, m := range acc.Metrics {
+		_, ok := m.Fields["entries_ratio"]
+		assert.False(t, ok, "entries_ratio should be omitted when ip_conntrack_max is 0")
+	}
+}
+
+func TestProcPathPrependsDirs(t *testing.T) {
+	defer restoreDflts(dfltFiles, dfltDirs)
+
+	tmpdir, err := ioutil.TempDir("", "procfs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	nestedDir := path.Join(tmpdir, "proc", "sys", "net", "netfilter")
+	assert.NoError(t, os.MkdirAll(nestedDir, 0755))
+
+	cntFile := path.Join(nestedDir, "nf_conntrack_count")
+	count := 42
+	assert.NoError(t, ioutil.WriteFile(cntFile, []byte(strconv.Itoa(count)), 0660))
+
+	c := &Conntrack{
+		ProcPath: tmpdir,
+		Dirs:     []string{"/proc/sys/net/netfilter"},
+		Files:    []string{"nf_conntrack_count"},
+	}
+	acc := &testutil.Accumulator{}
+
+	assert.NoError(t, c.Gather(acc))
+	acc.AssertContainsFields(t, inputName, map[string]interface{}{
+		"ip_conntrack_count": float64(count)})
+}
+
+func TestGatherPerState(t *testing.T) {
+	defer restoreDflts(dfltFiles, dfltDirs)
+
+	tmpdir, err := ioutil.TempDir("", "procfs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	netDir := path.Join(tmpdir, "proc", "net")
+	assert.NoError(t, os.MkdirAll(netDir, 0755))
+
+	table := strings.Join([]string{
+		"ipv4     2 tcp      6 108 ESTABLISHED src=10.0.2.2 dst=10.0.2.15 sport=1 dport=2 [ASSURED] mark=0 use=1",
+		"ipv4     2 tcp      6 30 TIME_WAIT src=10.0.2.2 dst=10.0.2.15 sport=3 dport=4 [ASSURED] mark=0 use=1",
+		"ipv4     2 udp      17 29 src=10.0.2.2 dst=10.0.2.15 sport=5 dport=6 src=10.0.2.15 dst=10.0.2.2 mark=0 use=1",
+		"ipv6     10 tcp     6 108 ESTABLISHED src=fe80::1 dst=fe80::2 sport=7 dport=8 [ASSURED] mark=0 use=1",
+		"not a conntrack line at all",
+	}, "\n") + "\n"
+
+	assert.NoError(t, ioutil.WriteFile(path.Join(netDir, "nf_conntrack"), []byte(table), 0640))
+
+	sysDir := path.Join(tmpdir, "proc", "sys", "net", "netfilter")
+	assert.NoError(t, os.MkdirAll(sysDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(path.Join(sysDir, "nf_conntrack_count"), []byte("4"), 0640))
+
+	c := &Conntrack{
+		ProcPath:        tmpdir,
+		Dirs:            []string{"/proc/sys/net/netfilter"},
+		Files:           []string{"nf_conntrack_count"},
+		CollectPerState: true,
+	}
+	acc := &testutil.Accumulator{}
+
+	assert.NoError(t, c.Gather(acc))
+
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(3)},
+		map[string]string{"l4proto": "tcp"})
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(1)},
+		map[string]string{"l4proto": "udp"})
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(2)},
+		map[string]string{"l4proto": "tcp", "state": "ESTABLISHED"})
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": int64(1)},
+		map[string]string{"l4proto": "tcp", "state": "TIME_WAIT"})
+
+	for _, m := range acc.Metrics {
+		l4proto, ok := m.Tags["l4proto"]
+		if !ok {
+			continue
+		}
+		assert.Contains(t, []string{"tcp", "udp"}, l4proto,
+			"malformed row %q should not have produced an l4proto tag", "not a conntrack line at all")
+	}
+}
+
 func TestCollectStats(t *testing.T) {
 	var mps system.MockPS
 	defer mps.AssertExpectations(t)
@@ -154,3 +278,104 @@ func TestCollectStats(t *testing.T) {
 
 	acc.AssertContainsTaggedFields(t, inputName, expectedFields, expectedTags)
 }
+
+func TestCollectStatsMissingFields(t *testing.T) {
+	// legacyStats models a kernel older than 2.6.35, which never
+	// populates search_restart.
+	legacyStats := net.ConntrackStat{
+		Entries:  1234,
+		Searched: 10,
+		Found:    1,
+	}
+
+	tests := []struct {
+		name                string
+		reportMissingAsZero bool
+		wantFields          map[string]interface{}
+		wantAbsent          []string
+	}{
+		{
+			name:                "missing fields omitted by default",
+			reportMissingAsZero: false,
+			wantFields: map[string]interface{}{
+				"entries":  uint32(1234),
+				"searched": uint32(10),
+				"found":    uint32(1),
+			},
+			wantAbsent: []string{"new", "invalid", "search_restart"},
+		},
+		{
+			name:                "report_missing_as_zero restores all fields",
+			reportMissingAsZero: true,
+			wantFields: map[string]interface{}{
+				"entries":        uint32(1234),
+				"searched":       uint32(10),
+				"found":          uint32(1),
+				"new":            uint32(0),
+				"invalid":        uint32(0),
+				"ignore":         uint32(0),
+				"delete":         uint32(0),
+				"delete_list":    uint32(0),
+				"insert":         uint32(0),
+				"insert_failed":  uint32(0),
+				"drop":           uint32(0),
+				"early_drop":     uint32(0),
+				"icmp_error":     uint32(0),
+				"expect_new":     uint32(0),
+				"expect_create":  uint32(0),
+				"expect_delete":  uint32(0),
+				"search_restart": uint32(0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mps system.MockPS
+			defer mps.AssertExpectations(t)
+			var acc testutil.Accumulator
+
+			mps.On("NetConntrack", false).Return([]net.ConntrackStat{legacyStats}, nil)
+
+			c := NewConntrack(&mps)
+			c.ReportMissingAsZero = tt.reportMissingAsZero
+
+			c.gatherConntrackStats(&acc)
+
+			acc.AssertContainsTaggedFields(t, inputName, tt.wantFields,
+				map[string]string{"cpu": "all"})
+			for _, absent := range tt.wantAbsent {
+				for _, m := range acc.Metrics {
+					_, ok := m.Fields[absent]
+					assert.False(t, ok, "expected field %q to be absent", absent)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectStatsPerCPUAggregate(t *testing.T) {
+	var mps system.MockPS
+	defer mps.AssertExpectations(t)
+	var acc testutil.Accumulator
+
+	cpu0 := net.ConntrackStat{Entries: 100, Searched: 10}
+	cpu1 := net.ConntrackStat{Entries: 50, Searched: 5}
+
+	mps.On("NetConntrack", true).Return([]net.ConntrackStat{cpu0, cpu1}, nil)
+
+	c := NewConntrack(&mps)
+	c.PerCPU = true
+
+	c.gatherConntrackStats(&acc)
+
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": uint32(100), "searched": uint32(10)},
+		map[string]string{"cpu": "cpu0"})
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": uint32(50), "searched": uint32(5)},
+		map[string]string{"cpu": "cpu1"})
+	acc.AssertContainsTaggedFields(t, inputName,
+		map[string]interface{}{"entries": uint32(150), "searched": uint32(15)},
+		map[string]string{"cpu": "all"})
+}